@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"github.com/steveoc64/tiedot/commonfile"
 	"github.com/steveoc64/tiedot/tdlog"
+	"hash/crc32"
+	"sync"
 )
 
 const (
@@ -14,10 +16,43 @@ const (
 
 	COL_FILE_SIZE   = uint64(512 * 1024 * 1) // Size of collection data file
 	DOC_MAX_ROOM    = uint64(512 * 1024 * 1) // Max single document size
-	DOC_HEADER_SIZE = 1 + 10                 // Size of document header - validity (byte), document room (uint64)
+	DOC_HEADER_SIZE = 1 + 10                 // Size of a version-1 document header - validity (byte), document room (uint64)
 	DOC_VALID       = byte(1)                // Document valid flag
 	DOC_INVALID     = byte(0)                // Document invalid flag
 
+	// UVARINT_SIZE is the width of the uvarint-encoded room field inside a
+	// document header, for both file versions.
+	UVARINT_SIZE = 10
+
+	// FileVersionLegacy identifies the original, unversioned collection
+	// file layout: documents start at offset 0, and there is no
+	// per-document checksum. A legacy file has no file header, so it is
+	// recognised by exclusion - see detectVersion.
+	FileVersionLegacy = byte(1)
+
+	// FileVersionCRC identifies a collection file that starts with a
+	// 1-byte version header and gives every document header a trailing
+	// CRC32C (Castagnoli) checksum of the document's room (including its
+	// padding). OpenCol creates new files in this format.
+	FileVersionCRC = byte(2)
+
+	// FILE_HEADER_SIZE is the size of the file header written at offset 0
+	// of a FileVersionCRC file. Legacy files have no such header: their
+	// first byte is simply the first document's validity byte
+	// (DOC_VALID or DOC_INVALID), neither of which collides with
+	// FileVersionCRC.
+	FILE_HEADER_SIZE = uint64(1)
+
+	// DOC_HEADER_SIZE_CRC is the per-document header size once a CRC32C
+	// checksum is appended to the legacy validity+room header.
+	DOC_HEADER_SIZE_CRC = DOC_HEADER_SIZE + 4
+
+	// MAX_RESYNC_WINDOW bounds how far ForAll will scan past a corrupted
+	// document header while looking for the next plausible one, so a
+	// maliciously or accidentally corrupted file cannot turn a single
+	// bad header into an unbounded scan.
+	MAX_RESYNC_WINDOW = DOC_MAX_ROOM * 2
+
 	// Pre-compiled document padding (2048 spaces)
 	PADDING = "                                                                                                                                " +
 		"                                                                                                                                " +
@@ -30,58 +65,263 @@ const (
 	LEN_PADDING = uint64(len(PADDING))
 )
 
+// crc32cTable is the lookup table for CRC32C (Castagnoli), the same
+// polynomial used by iSCSI, ext4 and SSE4.2's CRC32 instruction.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 type ColFile struct {
 	File *commonfile.File
+
+	// MaxDocumentSize caps the "room" a document header is allowed to
+	// declare, in bytes. Zero means "use DOC_MAX_ROOM". It exists so a
+	// deployment can shrink the untrusted-length ceiling below the
+	// theoretical maximum the on-disk format allows.
+	MaxDocumentSize uint64
+
+	// version is the on-disk layout of this file, detected by OpenCol:
+	// FileVersionLegacy for pre-existing files, FileVersionCRC for new
+	// ones.
+	version byte
+
+	// Lookup translates a caller's id to this document's current physical
+	// offset, for ids whose document Compact, or Update's realloc fallback,
+	// has moved since it was first inserted. It stays nil until the first
+	// Compact call or the first Update that has to move a document creates
+	// it, so collections that never do either pay nothing for this.
+	Lookup *LookupTable
+
+	// mu guards col.File and col.version against the swap Compact performs
+	// once it has finished writing a compacted replacement file.
+	mu sync.RWMutex
+}
+
+// resolveID translates id through col.Lookup, if the collection has ever
+// recorded a move for it - either because Compact relocated its document,
+// or because Update had to reinsert it at a larger offset. A document that
+// has never moved simply isn't in the table, so id - which is exactly the
+// physical offset Insert returned for it - is used unchanged.
+//
+// Lookup entries are always a single hop from a document's true physical
+// offset: Compact and Update's realloc fallback both collapse every entry
+// that pointed at an offset they just vacated, rewriting it to point
+// straight at the document's new offset (see LookupTable.CollapseValue),
+// rather than letting old and new mappings chain together. That collapsing
+// is what makes a plain lookup here safe - a raw physical offset can be
+// reused by a later, unrelated document once its old occupant moves on,
+// and a chain would have no way to tell "id, resolve me further" apart
+// from "id, a live physical offset already" once the two numbers collide.
+//
+// Once col.Lookup exists, insertLocked also stops handing out physical
+// offsets directly and mints disjoint logical ids (>= logicalIDBase)
+// instead, so a brand-new document's id can never itself collide with an
+// existing Lookup key. A logical id therefore always has a Lookup entry; a
+// miss on one is reported as ErrUnknownID rather than being used as a raw
+// offset.
+func (col *ColFile) resolveID(id uint64) (uint64, error) {
+	if col.Lookup == nil {
+		return id, nil
+	}
+	physOffset, ok := col.Lookup.Lookup(id)
+	if !ok {
+		if id >= logicalIDBase {
+			return 0, ErrUnknownID
+		}
+		return id, nil
+	}
+	return physOffset, nil
+}
+
+// docHeader is the decoded form of a document header, independent of which
+// file version it came from.
+type docHeader struct {
+	validity byte
+	room     uint64
+	crc      uint32 // only meaningful when the file is FileVersionCRC
 }
 
 // Open a collection file.
 func OpenCol(name string) (*ColFile, error) {
 	file, err := commonfile.Open(name, COL_FILE_SIZE)
-	return &ColFile{File: file}, err
+	if err != nil {
+		return nil, err
+	}
+	col := &ColFile{File: file}
+	col.detectVersion()
+	return col, nil
 }
 
-// Retrieve document data given its ID.
-func (col *ColFile) Read(id uint64) []byte {
-	if col.File.UsedSize < DOC_HEADER_SIZE || id >= col.File.UsedSize-DOC_HEADER_SIZE {
-		return nil
-	}
-	if col.File.Buf[id] != DOC_VALID {
-		return nil
+// detectVersion decides which on-disk layout this collection file uses. A
+// brand new (empty) file is initialised as FileVersionCRC. An existing file
+// is FileVersionCRC only if its first byte is the FileVersionCRC marker;
+// otherwise it predates this feature and is treated as FileVersionLegacy,
+// so collections written by older versions of tiedot keep working.
+func (col *ColFile) detectVersion() {
+	if col.File.UsedSize == 0 {
+		col.version = FileVersionCRC
+		col.File.Buf[0] = FileVersionCRC
+		col.File.UsedSize = FILE_HEADER_SIZE
+		return
 	}
-	if room, _ := binary.Uvarint(col.File.Buf[id+1 : id+11]); room > DOC_MAX_ROOM {
-		return nil
+	if col.File.Buf[0] == FileVersionCRC {
+		col.version = FileVersionCRC
 	} else {
-		docCopy := make([]byte, room)
-		docEnd := id + DOC_HEADER_SIZE + room
-		if docEnd >= col.File.Size {
-			return nil
-		}
-		copy(docCopy, col.File.Buf[id+DOC_HEADER_SIZE:docEnd])
-		return docCopy
+		col.version = FileVersionLegacy
+	}
+}
+
+// maxDocumentSize returns the effective document size cap for this file.
+func (col *ColFile) maxDocumentSize() uint64 {
+	if col.MaxDocumentSize == 0 {
+		return DOC_MAX_ROOM
+	}
+	return col.MaxDocumentSize
+}
+
+// docHeaderSize returns the per-document header size for this file's
+// version.
+func (col *ColFile) docHeaderSize() uint64 {
+	if col.version == FileVersionCRC {
+		return DOC_HEADER_SIZE_CRC
+	}
+	return DOC_HEADER_SIZE
+}
+
+// dataStart returns the offset of the first document header in this file:
+// right after the file header for FileVersionCRC, or the very beginning for
+// a legacy file with no file header at all.
+func (col *ColFile) dataStart() uint64 {
+	if col.version == FileVersionCRC {
+		return FILE_HEADER_SIZE
 	}
+	return 0
+}
+
+// readHeader decodes the document header at addr, without trusting any of
+// its fields yet - range and content checks are the caller's responsibility.
+// It only returns ErrCorruptHeader when the uvarint room field itself fails
+// to decode.
+func (col *ColFile) readHeader(addr uint64) (docHeader, error) {
+	var h docHeader
+	h.validity = col.File.Buf[addr]
+	room, n := binary.Uvarint(col.File.Buf[addr+1 : addr+1+UVARINT_SIZE])
+	if n <= 0 {
+		return docHeader{}, ErrCorruptHeader
+	}
+	h.room = room
+	if col.version == FileVersionCRC {
+		h.crc = binary.BigEndian.Uint32(col.File.Buf[addr+1+UVARINT_SIZE : addr+DOC_HEADER_SIZE_CRC])
+	}
+	return h, nil
+}
+
+// writeHeader writes the validity and room fields of a document header at
+// addr, plus its CRC32C checksum (of roomBytes, the full room-sized region
+// including padding) when the file is FileVersionCRC.
+func (col *ColFile) writeHeader(addr uint64, validity byte, room uint64, roomBytes []byte) {
+	col.File.Buf[addr] = validity
+	binary.PutUvarint(col.File.Buf[addr+1:addr+1+UVARINT_SIZE], room)
+	if col.version == FileVersionCRC {
+		crc := crc32.Checksum(roomBytes, crc32cTable)
+		binary.BigEndian.PutUint32(col.File.Buf[addr+1+UVARINT_SIZE:addr+DOC_HEADER_SIZE_CRC], crc)
+	}
+}
+
+// Retrieve document data given its ID. Returns (nil, nil) for a tombstoned
+// document, and a non-nil error if the header, declared document length, or
+// (for FileVersionCRC files) checksum cannot be trusted.
+func (col *ColFile) Read(id uint64) ([]byte, error) {
+	col.mu.RLock()
+	defer col.mu.RUnlock()
+	id, err := col.resolveID(id)
+	if err != nil {
+		return nil, err
+	}
+	hdrSize := col.docHeaderSize()
+	if col.File.UsedSize < hdrSize || id > col.File.UsedSize-hdrSize {
+		return nil, ErrOutOfBounds
+	}
+	hdr, err := col.readHeader(id)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.validity == DOC_INVALID {
+		return nil, nil
+	}
+	if hdr.validity != DOC_VALID {
+		return nil, ErrCorruptHeader
+	}
+	if hdr.room > col.maxDocumentSize() {
+		return nil, ErrDocTooLarge
+	}
+	docEnd := id + hdrSize + hdr.room
+	if docEnd < id+hdrSize || docEnd > col.File.UsedSize {
+		return nil, ErrOutOfBounds
+	}
+	roomBytes := col.File.Buf[id+hdrSize : docEnd]
+	if col.version == FileVersionCRC && crc32.Checksum(roomBytes, crc32cTable) != hdr.crc {
+		return nil, ErrChecksumMismatch
+	}
+	docCopy := make([]byte, hdr.room)
+	copy(docCopy, roomBytes)
+	return docCopy, nil
 }
 
 // Insert a document, return its ID.
 func (col *ColFile) Insert(data []byte) (id uint64, err error) {
+	col.mu.RLock()
+	defer col.mu.RUnlock()
+	return col.insertLocked(data)
+}
+
+// insertLocked is Insert's body, factored out so Update's fallback path can
+// call it without taking col.mu a second time - sync.RWMutex's read lock
+// is not safely re-entrant across a pending writer.
+func (col *ColFile) insertLocked(data []byte) (id uint64, err error) {
+	physOffset, err := col.writeNewDoc(data)
+	if err != nil {
+		return 0, err
+	}
+	if col.Lookup == nil {
+		return physOffset, nil
+	}
+	// col.Lookup already holds at least one physical-offset-keyed entry
+	// (from a Compact or an earlier Update reallocation), so handing out
+	// physOffset itself as this document's id risks it later being
+	// compacted away and that same offset value reused as someone else's
+	// stale remap key. Mint a disjoint logical id instead - see resolveID.
+	return col.Lookup.AllocateID(physOffset)
+}
+
+// writeNewDoc writes data as a brand new document at the end of the file
+// and returns its physical offset, without deciding what id to hand the
+// caller for it - insertLocked wraps this to answer Insert, and Update's
+// realloc fallback calls it directly so it can pick the id itself (see
+// Update).
+func (col *ColFile) writeNewDoc(data []byte) (physOffset uint64, err error) {
+	hdrSize := col.docHeaderSize()
 	len64 := uint64(len(data))
 	room := len64 + len64
-	if room > DOC_MAX_ROOM {
+	if room > col.maxDocumentSize() {
 		err = errors.New(fmt.Sprintf("Document is too large"))
 		return
 	}
-	// Keep track of new document ID and used space
-	id = col.File.UsedSize
-	if !col.File.CheckSize(DOC_HEADER_SIZE + room) {
-		col.File.CheckSizeAndEnsure(DOC_HEADER_SIZE + room)
-	}
-	col.File.UsedSize = id + DOC_HEADER_SIZE + room
-	// Make document header, then copy document data
-	col.File.Buf[id] = 1
-	binary.PutUvarint(col.File.Buf[id+1:id+DOC_HEADER_SIZE], room)
-	paddingBegin := id + DOC_HEADER_SIZE + len64
-	copy(col.File.Buf[id+DOC_HEADER_SIZE:paddingBegin], data)
-	// Fill up padding space
-	paddingEnd := id + DOC_HEADER_SIZE + room
+	// Keep track of the new document's physical offset and used space.
+	// UsedSize is normally already past dataStart() - OpenCol/detectVersion
+	// sees to that - but clamp anyway so a file header is never clobbered by
+	// a document written at offset 0.
+	physOffset = col.File.UsedSize
+	if physOffset < col.dataStart() {
+		physOffset = col.dataStart()
+	}
+	if !col.File.CheckSize(hdrSize + room) {
+		col.File.CheckSizeAndEnsure(hdrSize + room)
+	}
+	col.File.UsedSize = physOffset + hdrSize + room
+	// Copy document data and fill up padding space, then write the header
+	// (which, for FileVersionCRC, checksums the room we just wrote).
+	paddingBegin := physOffset + hdrSize + len64
+	copy(col.File.Buf[physOffset+hdrSize:paddingBegin], data)
+	paddingEnd := physOffset + hdrSize + room
 	for segBegin := paddingBegin; segBegin < paddingEnd; segBegin += LEN_PADDING {
 		segSize := LEN_PADDING
 		segEnd := segBegin + LEN_PADDING
@@ -92,56 +332,133 @@ func (col *ColFile) Insert(data []byte) (id uint64, err error) {
 		}
 		copy(col.File.Buf[segBegin:segEnd], PADDING[0:segSize])
 	}
-	return
+	col.writeHeader(physOffset, DOC_VALID, room, col.File.Buf[physOffset+hdrSize:paddingEnd])
+	return physOffset, nil
 }
 
 // Update a document, return its new ID.
 func (col *ColFile) Update(id uint64, data []byte) (newID uint64, err error) {
+	col.mu.RLock()
+	defer col.mu.RUnlock()
+	logicalID := id
+	id, err = col.resolveID(id)
+	if err != nil {
+		return 0, err
+	}
+	hdrSize := col.docHeaderSize()
 	len64 := uint64(len(data))
-	if len64 > DOC_MAX_ROOM {
+	if len64 > col.maxDocumentSize() {
 		err = errors.New(fmt.Sprintf("Updated document is too large"))
 		return
 	}
-	if col.File.UsedSize < DOC_HEADER_SIZE || id >= col.File.UsedSize-DOC_HEADER_SIZE {
+	if col.File.UsedSize < hdrSize || id > col.File.UsedSize-hdrSize {
 		err = errors.New(fmt.Sprintf("Document %d does not exist in %s", id, col.File.Name))
 		return
 	}
-	if col.File.Buf[id] != DOC_VALID {
+	hdr, headerErr := col.readHeader(id)
+	if headerErr != nil {
+		err = headerErr
+		return
+	}
+	if hdr.validity != DOC_VALID {
 		err = errors.New(fmt.Sprintf("Document %d does not exist in %s", id, col.File.Name))
 		return
 	}
-	if room, _ := binary.Uvarint(col.File.Buf[id+1 : id+11]); room > DOC_MAX_ROOM || id+room >= col.File.UsedSize {
+	docEnd := id + hdrSize + hdr.room
+	if hdr.room > col.maxDocumentSize() || docEnd < id || docEnd > col.File.UsedSize {
 		err = errors.New(fmt.Sprintf("Document %d does not exist in %s", id, col.File.Name))
 		return
-	} else {
-		if len64 <= room {
-			// There is enough room for the updated document
-			// Overwrite document data
-			paddingBegin := id + DOC_HEADER_SIZE + len64
-			copy(col.File.Buf[id+DOC_HEADER_SIZE:paddingBegin], data)
-			// Overwrite padding space
-			paddingEnd := id + DOC_HEADER_SIZE + room
-			for segBegin := paddingBegin; segBegin < paddingEnd; segBegin += LEN_PADDING {
-				segSize := LEN_PADDING
-				segEnd := segBegin + LEN_PADDING
-
-				if segEnd >= paddingEnd {
-					segEnd = paddingEnd
-					segSize = paddingEnd - segBegin
-				}
-				copy(col.File.Buf[segBegin:segEnd], PADDING[0:segSize])
+	}
+	if len64 <= hdr.room {
+		// There is enough room for the updated document
+		// Overwrite document data
+		paddingBegin := id + hdrSize + len64
+		copy(col.File.Buf[id+hdrSize:paddingBegin], data)
+		// Overwrite padding space
+		paddingEnd := docEnd
+		for segBegin := paddingBegin; segBegin < paddingEnd; segBegin += LEN_PADDING {
+			segSize := LEN_PADDING
+			segEnd := segBegin + LEN_PADDING
+
+			if segEnd >= paddingEnd {
+				segEnd = paddingEnd
+				segSize = paddingEnd - segBegin
 			}
-			return id, nil
+			copy(col.File.Buf[segBegin:segEnd], PADDING[0:segSize])
 		}
-		// There is not enough room for updated content, so delete the original document and re-insert
-		col.Delete(id)
-		return col.Insert(data)
+		col.writeHeader(id, DOC_VALID, hdr.room, col.File.Buf[id+hdrSize:paddingEnd])
+		return id, nil
+	}
+	// There is not enough room for updated content, so delete the original
+	// document and re-insert. The new document lives at a different
+	// physical offset, so the caller's id (which resolveID may itself
+	// already have translated, if this document had moved under a previous
+	// Compact or Update) must keep resolving to it from now on.
+	oldOffset := id
+	col.deleteLocked(id)
+	newOffset, err := col.writeNewDoc(data)
+	if err != nil {
+		return 0, err
+	}
+	if col.Lookup == nil {
+		lt, lerr := OpenLookupTable(col.File.Name + ".lookup")
+		if lerr != nil {
+			return 0, lerr
+		}
+		col.Lookup = lt
+	}
+	// oldOffset is now tombstoned and free to be reused by some other
+	// document, so any existing entry still pointing at it must be
+	// collapsed onto newOffset first - otherwise resolving that other id
+	// later would land on whatever gets inserted at oldOffset next, not the
+	// document it actually belongs to (see resolveID). logicalID is mapped
+	// straight to newOffset, not through a second id, so resolveID's single
+	// lookup still finds the document in one hop.
+	if lerr := col.Lookup.CollapseValue(oldOffset, newOffset); lerr != nil {
+		return 0, lerr
 	}
+	if lerr := col.Lookup.Set(logicalID, newOffset); lerr != nil {
+		return 0, lerr
+	}
+	// The caller gets back a fresh logical id rather than newOffset itself,
+	// for the same reason insertLocked mints one for a plain Insert: a raw
+	// offset handed out now could later double as a stale Lookup key once
+	// this document moves again.
+	return col.Lookup.AllocateID(newOffset)
+}
+
+// Sync flushes the collection file's in-memory changes to disk.
+func (col *ColFile) Sync() error {
+	col.mu.RLock()
+	defer col.mu.RUnlock()
+	return col.File.Sync()
+}
+
+// Close releases the collection file's resources.
+func (col *ColFile) Close() error {
+	col.mu.RLock()
+	defer col.mu.RUnlock()
+	return col.File.Close()
 }
 
-// Delete a document.
+// Delete a document. An id that resolveID cannot place (for instance a
+// logical id the Lookup table has no record of) is silently ignored, the
+// same as deleting an id that is merely out of bounds.
 func (col *ColFile) Delete(id uint64) {
-	if col.File.UsedSize < DOC_HEADER_SIZE || id >= col.File.UsedSize-DOC_HEADER_SIZE {
+	col.mu.RLock()
+	defer col.mu.RUnlock()
+	resolved, err := col.resolveID(id)
+	if err != nil {
+		return
+	}
+	col.deleteLocked(resolved)
+}
+
+// deleteLocked is Delete's body, factored out so Update's fallback path can
+// call it without taking col.mu a second time.
+func (col *ColFile) deleteLocked(id uint64) {
+	hdrSize := col.docHeaderSize()
+	if col.File.UsedSize < hdrSize || id > col.File.UsedSize-hdrSize {
 		return
 	}
 	if col.File.Buf[id] == DOC_VALID {
@@ -149,29 +466,66 @@ func (col *ColFile) Delete(id uint64) {
 	}
 }
 
-// Scan the entire data file, look for documents and invoke the function on each.
-func (col *ColFile) ForAll(fun func(id uint64, doc []byte) bool) {
-	addr := uint64(0)
+// Scan the entire data file, look for documents and invoke the function on
+// each. Scanning stops early, without error, if fun returns false. It
+// returns a non-nil error if the file is corrupted badly enough that
+// scanning cannot safely continue (a document overruns the file, or a
+// corrupted header cannot be resynchronised within MAX_RESYNC_WINDOW
+// bytes) - in that case, fun has already been invoked for every valid
+// document found before the corruption. ForAll does not verify per-document
+// checksums; use Verify for that.
+func (col *ColFile) ForAll(fun func(id uint64, doc []byte) bool) error {
+	col.mu.RLock()
+	defer col.mu.RUnlock()
+	hdrSize := col.docHeaderSize()
+	maxRoom := col.maxDocumentSize()
+	addr := col.dataStart()
 	for {
-		if col.File.UsedSize < DOC_HEADER_SIZE || addr >= col.File.UsedSize-DOC_HEADER_SIZE {
+		if col.File.UsedSize < hdrSize || addr > col.File.UsedSize-hdrSize {
 			break
 		}
-		// Read document header - validity and room
-		validity := col.File.Buf[addr]
-		room, _ := binary.Uvarint(col.File.Buf[addr+1 : addr+11])
-		if validity != DOC_VALID && validity != DOC_INVALID || room > DOC_MAX_ROOM {
-			// If the document does not contain valid header, skip it
+		hdr, headerErr := col.readHeader(addr)
+		if headerErr != nil || (hdr.validity != DOC_VALID && hdr.validity != DOC_INVALID) || hdr.room > maxRoom {
 			tdlog.Errorf("ERROR: The document at %d in %s is corrupted", addr, col.File.Name)
-			// Move forward until we meet a valid document header
-			for addr++; col.File.Buf[addr] != DOC_VALID && col.File.Buf[addr] != DOC_INVALID && addr < col.File.UsedSize-DOC_HEADER_SIZE; addr++ {
+			next, ok := col.resync(addr)
+			if !ok {
+				return ErrCorruptHeader
 			}
-			tdlog.Errorf("ERROR: Corrupted document skipped, now at %d", addr)
+			tdlog.Errorf("ERROR: Corrupted document skipped, now at %d", next)
+			addr = next
 			continue
 		}
+		docEnd := addr + hdrSize + hdr.room
+		if docEnd < addr+hdrSize || docEnd > col.File.UsedSize {
+			tdlog.Errorf("ERROR: The document at %d in %s overruns the file", addr, col.File.Name)
+			return ErrOutOfBounds
+		}
 		// If the function returns false, do not continue scanning
-		if validity == DOC_VALID && !fun(addr, col.File.Buf[addr+DOC_HEADER_SIZE:addr+DOC_HEADER_SIZE+room]) {
+		if hdr.validity == DOC_VALID && !fun(addr, col.File.Buf[addr+hdrSize:docEnd]) {
 			break
 		}
-		addr += DOC_HEADER_SIZE + room
+		addr = docEnd
+	}
+	return nil
+}
+
+// resync advances from a corrupted document header at addr to the next
+// address holding a plausible validity byte, searching at most
+// MAX_RESYNC_WINDOW bytes so a corrupted file cannot force an unbounded
+// scan. It returns the address to resume from, or ok=false if no
+// plausible header was found within the window or the file bounds.
+func (col *ColFile) resync(addr uint64) (next uint64, ok bool) {
+	hdrSize := col.docHeaderSize()
+	if col.File.UsedSize < hdrSize {
+		return 0, false
+	}
+	limit := col.File.UsedSize - hdrSize
+	scanned := uint64(0)
+	for addr++; addr <= limit && scanned < MAX_RESYNC_WINDOW; addr, scanned = addr+1, scanned+1 {
+		v := col.File.Buf[addr]
+		if v == DOC_VALID || v == DOC_INVALID {
+			return addr, true
+		}
 	}
+	return 0, false
 }
@@ -0,0 +1,199 @@
+package chunkfile
+
+import (
+	"github.com/steveoc64/tiedot/tdlog"
+	"os"
+	"sync"
+	"time"
+)
+
+// CompactStats reports the outcome of a Compact pass.
+type CompactStats struct {
+	BytesReclaimed int64 // shrinkage in the collection file's UsedSize
+	Remapped       int   // ids whose document moved to a new physical offset
+}
+
+// compactMu serialises Compact against itself; col.mu only protects the
+// moment Compact swaps col.File in, not the (much longer) copy beforehand.
+var compactMu sync.Mutex
+
+// trimPadding strips the trailing space padding Insert and Update fill a
+// document's unused room with, so Compact doesn't re-insert a document
+// twice as large as it needs to be.
+func trimPadding(doc []byte) []byte {
+	end := len(doc)
+	for end > 0 && doc[end-1] == ' ' {
+		end--
+	}
+	return doc[:end]
+}
+
+// Compact reclaims the space held by tombstoned documents and the slack
+// Update leaves behind. It streams every live document into a fresh
+// sibling file via ForAll, then swaps that file in for col.File under a
+// write lock, so readers never observe a half-swapped collection.
+//
+// An id a caller is holding may simply be the physical byte offset Insert
+// first returned for it, and compaction moves that offset. Compact records
+// the move in col.Lookup (creating one alongside the collection file the
+// first time it's needed) so Read, Update and Delete keep accepting
+// whichever id a caller was already holding, and collapses any entry that
+// already pointed at the vacated offset onto the new one, so a later
+// unrelated document minted at that same offset can never be misread as
+// the one that used to live there - see resolveID.
+func (col *ColFile) Compact() (CompactStats, error) {
+	compactMu.Lock()
+	defer compactMu.Unlock()
+
+	var stats CompactStats
+
+	if col.Lookup == nil {
+		lt, err := OpenLookupTable(col.File.Name + ".lookup")
+		if err != nil {
+			return stats, err
+		}
+		col.Lookup = lt
+	}
+
+	tmpName := col.File.Name + ".compact.tmp"
+	os.Remove(tmpName) // in case a previous attempt crashed before cleaning up
+	tmpCol, err := OpenCol(tmpName)
+	if err != nil {
+		return stats, err
+	}
+	tmpCol.MaxDocumentSize = col.MaxDocumentSize
+
+	type move struct{ oldID, newID uint64 }
+	var moves []move
+	var insertErr error
+	scanErr := col.ForAll(func(id uint64, doc []byte) bool {
+		newID, err := tmpCol.Insert(trimPadding(doc))
+		if err != nil {
+			insertErr = err
+			return false
+		}
+		if newID != id {
+			moves = append(moves, move{oldID: id, newID: newID})
+		}
+		return true
+	})
+	if insertErr != nil {
+		err = insertErr
+	} else {
+		err = scanErr
+	}
+	if err != nil {
+		tmpCol.Close()
+		os.Remove(tmpName)
+		return stats, err
+	}
+	if err := tmpCol.Sync(); err != nil {
+		tmpCol.Close()
+		os.Remove(tmpName)
+		return stats, err
+	}
+
+	oldName := col.File.Name
+	beforeSize := col.File.UsedSize
+
+	col.mu.Lock()
+	oldFile := col.File
+	col.File = tmpCol.File
+	col.version = tmpCol.version
+	// The moves are recorded before the write lock is released, so no reader
+	// resolves an id against the new file through a mapping that still
+	// describes the old one.
+	var remapErr error
+	for _, m := range moves {
+		// m.oldID is about to stop meaning anything on its own - the old
+		// file has just been swapped out - so any existing entry still
+		// pointing at it as a physical offset must be collapsed onto m.newID
+		// first. Otherwise that entry would keep resolving to whatever a
+		// later, unrelated insert happens to place at m.oldID's old spot once
+		// this file is compacted again (see resolveID).
+		if remapErr = col.Lookup.CollapseValue(m.oldID, m.newID); remapErr != nil {
+			break
+		}
+		if remapErr = col.Lookup.Set(m.oldID, m.newID); remapErr != nil {
+			break
+		}
+	}
+	col.mu.Unlock()
+	oldFile.Close()
+	if remapErr != nil {
+		return stats, remapErr
+	}
+	if err := col.Lookup.Rewrite(); err != nil {
+		return stats, err
+	}
+	stats.Remapped = len(moves)
+	stats.BytesReclaimed = int64(beforeSize) - int64(col.File.UsedSize)
+
+	if err := os.Rename(tmpName, oldName); err != nil {
+		return stats, err
+	}
+	col.File.Name = oldName
+	return stats, nil
+}
+
+// AutoCompactPolicy configures when a collection should compact itself
+// automatically: whenever its live-to-total-bytes ratio drops below Ratio,
+// checked every Interval, but never while the file is still smaller than
+// MinBytes (so a handful of tombstones in a small collection don't trigger
+// constant rewrites).
+type AutoCompactPolicy struct {
+	Ratio    float64
+	MinBytes uint64
+	Interval time.Duration
+}
+
+// StartAutoCompact launches a goroutine that calls Compact whenever policy
+// says the collection is due for it, until the returned function is
+// called. db.OpenDB starts one of these per collection that asks for
+// auto-compaction, the same place every other driver's background upkeep
+// gets wired in.
+func (col *ColFile) StartAutoCompact(policy AutoCompactPolicy) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(policy.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if col.File.UsedSize < policy.MinBytes {
+					continue
+				}
+				ratio, err := col.liveRatio()
+				if err != nil {
+					tdlog.Errorf("ERROR: auto-compact: %v", err)
+					continue
+				}
+				if ratio < policy.Ratio {
+					if _, err := col.Compact(); err != nil {
+						tdlog.Errorf("ERROR: auto-compact: %v", err)
+					}
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// liveRatio returns the fraction of the file's used bytes that belong to
+// documents which are still valid (not tombstoned).
+func (col *ColFile) liveRatio() (float64, error) {
+	var live uint64
+	if err := col.ForAll(func(id uint64, doc []byte) bool {
+		live += uint64(len(doc))
+		return true
+	}); err != nil {
+		return 0, err
+	}
+	total := col.File.UsedSize
+	if total == 0 {
+		return 1, nil
+	}
+	return float64(live) / float64(total), nil
+}
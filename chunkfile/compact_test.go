@@ -0,0 +1,169 @@
+package chunkfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func newCompactTestCol(t *testing.T) (*ColFile, string) {
+	dir, err := ioutil.TempDir("", "chunkfile_compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := OpenCol(path.Join(dir, "col"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return col, dir
+}
+
+func TestCompactReclaimsTombstonedSpace(t *testing.T) {
+	col, dir := newCompactTestCol(t)
+	defer os.RemoveAll(dir)
+
+	var ids []uint64
+	for i := 0; i < 20; i++ {
+		id, err := col.Insert([]byte(fmt.Sprintf("document-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	for _, id := range ids[1:] {
+		col.Delete(id)
+	}
+
+	beforeSize := col.File.UsedSize
+	stats, err := col.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.BytesReclaimed <= 0 {
+		t.Fatalf("expected Compact to reclaim space, got %+v (file was %d bytes)", stats, beforeSize)
+	}
+
+	got, err := col.Read(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Read returns the document's full padded room, same as ever - trim it
+	// before comparing, same as any other ColFile reader must.
+	if string(trimPadding(got)) != "document-0" {
+		t.Fatalf("expected the surviving document to still read correctly by its original id, got %q", got)
+	}
+	for _, id := range ids[1:] {
+		// Compact drops tombstoned documents entirely rather than carrying
+		// them into the new file, so the old id may now fall past the end
+		// of the (much smaller) compacted file - ErrOutOfBounds is just as
+		// much "gone" as the (nil, nil) a still-in-range tombstone reads as.
+		got, err := col.Read(id)
+		if err != nil && err != ErrOutOfBounds {
+			t.Fatal(err)
+		}
+		if got != nil {
+			t.Fatalf("expected tombstoned document %d to read as deleted after compaction, got %q", id, got)
+		}
+	}
+}
+
+func TestCompactRemappedIDSupportsUpdateAndDelete(t *testing.T) {
+	col, dir := newCompactTestCol(t)
+	defer os.RemoveAll(dir)
+
+	first, err := col.Insert([]byte("keep"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := col.Insert([]byte("also keep"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	col.Delete(first)
+
+	if _, err := col.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	// "second" almost certainly moved to a new physical offset, since the
+	// tombstoned first document was dropped ahead of it.
+	if _, err := col.Update(second, []byte("updated after compaction")); err != nil {
+		t.Fatalf("Update on a remapped id failed: %v", err)
+	}
+	got, err := col.Read(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(trimPadding(got)) != "updated after compaction" {
+		t.Fatalf("expected updated content via the original id, got %q", got)
+	}
+
+	col.Delete(second)
+	if got, _ := col.Read(second); got != nil {
+		t.Fatalf("expected the remapped id to read as deleted, got %q", got)
+	}
+}
+
+func TestCompactDoesNotAliasFreshInsertWithRemappedID(t *testing.T) {
+	col, dir := newCompactTestCol(t)
+	defer os.RemoveAll(dir)
+
+	var ids []uint64
+	for i := 0; i < 3; i++ {
+		id, err := col.Insert([]byte(fmt.Sprintf("document-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	col.Delete(ids[0])
+
+	if _, err := col.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Insert after compaction, when a fresh document's physical offset is
+	// most likely to land on a value Compact just recorded as somebody
+	// else's remapped id.
+	freshID, err := col.Insert([]byte("brand-new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := col.Read(freshID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(trimPadding(got)) != "brand-new" {
+		t.Fatalf("expected the fresh id to read its own content, got %q", got)
+	}
+
+	for _, id := range ids[1:] {
+		if id == freshID {
+			t.Fatalf("fresh id %d aliased a pre-compaction id", freshID)
+		}
+		got, err := col.Read(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(trimPadding(got)) == "brand-new" {
+			t.Fatalf("reading pre-compaction id %d returned the fresh document instead of its own", id)
+		}
+	}
+
+	if _, err := col.Read(freshID); err != nil {
+		t.Fatal(err)
+	}
+	col.Delete(freshID)
+	if got, _ := col.Read(freshID); got != nil {
+		t.Fatalf("expected the fresh id to read as deleted after Delete, got %q", got)
+	}
+	// The pre-compaction survivors must still be unaffected by deleting the
+	// unrelated fresh document.
+	for _, id := range ids[1:] {
+		if got, err := col.Read(id); err != nil || got == nil {
+			t.Fatalf("expected id %d to still be readable, got %q, %v", id, got, err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package chunkfile
+
+import "fmt"
+
+// Driver is the storage backend for a single collection file. The rest of
+// tiedot programs against this interface instead of binding directly to the
+// mmap-backed ColFile, so a collection can be served by whichever backend
+// its deployment needs.
+type Driver interface {
+	Read(id uint64) ([]byte, error)
+	Insert(data []byte) (id uint64, err error)
+	Update(id uint64, data []byte) (newID uint64, err error)
+	Delete(id uint64)
+	ForAll(fun func(id uint64, doc []byte) bool) error
+	Sync() error
+	Close() error
+}
+
+// Opener constructs a Driver for the named collection. For file-backed
+// drivers name is a path; drivers that don't touch disk (the "mem" driver)
+// ignore it beyond using it as a label.
+type Opener func(name string) (Driver, error)
+
+var drivers = map[string]Opener{}
+
+// defaultDriverName is the driver Open falls back to when called with an
+// empty driverName. SetDefaultDriver changes it once at process startup,
+// so a deployment can choose its backend without every db.OpenDB call
+// site needing to name one explicitly; a collection can still request a
+// specific driver by passing a non-empty driverName to Open.
+var defaultDriverName = "mmap"
+
+// SetDefaultDriver makes name Open's fallback for an empty driverName. It
+// rejects an unregistered name immediately, rather than letting it through
+// to fail confusingly on the first Open call that relies on the default.
+func SetDefaultDriver(name string) error {
+	if _, ok := drivers[name]; !ok {
+		return fmt.Errorf("chunkfile: unknown driver %q", name)
+	}
+	defaultDriverName = name
+	return nil
+}
+
+// Register makes a driver available under name, for Open to construct by
+// that name. It panics if opener is nil or if name is already registered -
+// the same contract database/sql and image use for their own registries -
+// because both mistakes are programmer errors that should fail at init
+// time, not produce a confusing runtime error later.
+func Register(name string, opener Opener) {
+	if opener == nil {
+		panic("chunkfile: Register opener is nil for driver " + name)
+	}
+	if _, dup := drivers[name]; dup {
+		panic("chunkfile: Register called twice for driver " + name)
+	}
+	drivers[name] = opener
+}
+
+// Open constructs a Driver of the named kind for the given collection. An
+// empty driverName uses whatever SetDefaultDriver last configured (the
+// "mmap" backend, absent any call to it).
+func Open(driverName, name string) (Driver, error) {
+	if driverName == "" {
+		driverName = defaultDriverName
+	}
+	opener, ok := drivers[driverName]
+	if !ok {
+		return nil, fmt.Errorf("chunkfile: unknown driver %q", driverName)
+	}
+	return opener(name)
+}
+
+func init() {
+	Register("mmap", func(name string) (Driver, error) {
+		return OpenCol(name)
+	})
+}
@@ -0,0 +1,119 @@
+package chunkfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestMemColFileRoundTrip(t *testing.T) {
+	col, err := Open("mem", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close()
+
+	id, err := col.Insert([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := col.Read(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("expected %q, got %q", "hello", got)
+	}
+
+	if _, err := col.Update(id, []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = col.Read(id); string(got) != "world" {
+		t.Fatalf("expected %q after update, got %q", "world", got)
+	}
+
+	col.Delete(id)
+	if got, _ = col.Read(id); got != nil {
+		t.Fatalf("expected nil after delete, got %q", got)
+	}
+}
+
+func TestMemColFileForAllOrdered(t *testing.T) {
+	col, _ := Open("mem", "test")
+	defer col.Close()
+
+	var ids []uint64
+	for _, s := range []string{"a", "b", "c"} {
+		id, err := col.Insert([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	var seen []uint64
+	col.ForAll(func(id uint64, doc []byte) bool {
+		seen = append(seen, id)
+		return true
+	})
+	if len(seen) != len(ids) {
+		t.Fatalf("expected %d documents, saw %d", len(ids), len(seen))
+	}
+	for i := range seen {
+		if seen[i] != ids[i] {
+			t.Fatalf("expected ForAll in insertion order, got %v", seen)
+		}
+	}
+}
+
+func TestOpenUnknownDriver(t *testing.T) {
+	if _, err := Open("does-not-exist", "test"); err == nil {
+		t.Fatal("expected an error opening an unregistered driver")
+	}
+}
+
+func TestOpenEmptyDriverNameUsesDefault(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunkfile_driver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	col, err := Open("", path.Join(dir, "col"))
+	if err != nil {
+		t.Fatalf("expected an empty driverName to fall back to the default driver, got %v", err)
+	}
+	defer col.Close()
+}
+
+func TestSetDefaultDriverRejectsUnknownName(t *testing.T) {
+	if err := SetDefaultDriver("does-not-exist"); err == nil {
+		t.Fatal("expected an error setting an unregistered driver as default")
+	}
+}
+
+func TestSetDefaultDriverChangesOpenFallback(t *testing.T) {
+	if err := SetDefaultDriver("mem"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDefaultDriver("mmap")
+
+	col, err := Open("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close()
+	if _, ok := col.(*MemColFile); !ok {
+		t.Fatalf("expected the default driver to open a *MemColFile, got %T", col)
+	}
+}
+
+func TestRegisterDuplicatePanics(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("expected Register to panic on a duplicate driver name")
+		}
+	}()
+	Register("mem", func(name string) (Driver, error) { return OpenMemCol(name) })
+}
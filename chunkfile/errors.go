@@ -0,0 +1,32 @@
+package chunkfile
+
+import "errors"
+
+// Sentinel errors returned when a collection file's on-disk data cannot be
+// trusted. Callers that walk a collection (ForAll, Verify, Repair, ...)
+// should treat these as signals to stop trusting the current offset rather
+// than as reasons to panic or keep reading past it.
+var (
+	// ErrCorruptHeader is returned when a document header's validity byte or
+	// room field does not decode into a sane value.
+	ErrCorruptHeader = errors.New("chunkfile: corrupt document header")
+
+	// ErrDocTooLarge is returned when a document's declared room exceeds
+	// the collection's configured MaxDocumentSize.
+	ErrDocTooLarge = errors.New("chunkfile: document too large")
+
+	// ErrOutOfBounds is returned when a document's header and room would
+	// place its body outside the bounds of the collection file.
+	ErrOutOfBounds = errors.New("chunkfile: document out of bounds")
+
+	// ErrChecksumMismatch is returned by Read when a FileVersionCRC
+	// document's stored CRC32C checksum does not match its body.
+	ErrChecksumMismatch = errors.New("chunkfile: document checksum mismatch")
+
+	// ErrUnknownID is returned when a logical id minted by
+	// LookupTable.AllocateID has no entry in its collection's Lookup table.
+	// Such an id is never a physical offset in its own right, so unlike an
+	// ordinary miss it cannot fall back to "use the id as-is" - a miss here
+	// means the table itself is missing a record it must have written.
+	ErrUnknownID = errors.New("chunkfile: unknown document id")
+)
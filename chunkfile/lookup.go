@@ -0,0 +1,205 @@
+package chunkfile
+
+import (
+	"encoding/binary"
+	"os"
+	"sync"
+)
+
+// lookupRecordSize is the width of one (logical id, physical offset) pair
+// in a LookupTable's on-disk log.
+const lookupRecordSize = 16
+
+// logicalIDBase is the first id AllocateID ever hands out. It sits far
+// above any physical offset a collection file will realistically reach, so
+// a logical id can never collide with a physical-offset-keyed entry
+// already in the table - see ColFile.insertLocked and resolveID.
+const logicalIDBase = uint64(1) << 62
+
+// LookupTable maps a document's stable logical id - the offset Insert
+// originally returned for it - to its current physical offset in a
+// ColFile. Most ids never appear in it at all: a document that has never
+// moved simply has logical id == physical offset, so ColFile treats a miss
+// here as "use the id as-is". Compact populates it as it relocates
+// documents, so callers holding an id from before a compaction keep
+// reading the right document afterwards.
+//
+// It is persisted as a flat, append-only log of fixed-size records, the
+// same write-ahead-log recipe as commonfile.File elsewhere in this module
+// uses: every Set is fsync'd before it returns, and on load the last
+// record for a given id wins, so a crash mid-compaction just means
+// replaying a few extra records that Compact will end up rewriting anyway.
+type LookupTable struct {
+	mu      sync.RWMutex
+	file    *os.File
+	offsets map[uint64]uint64
+
+	// nextID is the next logical id AllocateID will hand out. It starts at
+	// logicalIDBase and is fast-forwarded past whatever replay finds
+	// already recorded, so restarting a process never reissues an id.
+	nextID uint64
+}
+
+// OpenLookupTable opens (or creates) the lookup table at name and replays
+// its log into memory.
+func OpenLookupTable(name string) (*LookupTable, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	lt := &LookupTable{file: f, offsets: make(map[uint64]uint64), nextID: logicalIDBase}
+	if err := lt.replay(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return lt, nil
+}
+
+// replay loads every complete record in the log into memory. A trailing
+// partial record - the tell-tale sign of a crash mid-append - is ignored.
+func (lt *LookupTable) replay() error {
+	info, err := lt.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	size -= size % lookupRecordSize
+	buf := make([]byte, size)
+	if _, err := lt.file.ReadAt(buf, 0); err != nil {
+		return err
+	}
+	for off := 0; off+lookupRecordSize <= len(buf); off += lookupRecordSize {
+		id := binary.BigEndian.Uint64(buf[off : off+8])
+		physOffset := binary.BigEndian.Uint64(buf[off+8 : off+16])
+		lt.offsets[id] = physOffset
+		if id >= logicalIDBase && id+1 > lt.nextID {
+			lt.nextID = id + 1
+		}
+	}
+	return nil
+}
+
+// Set durably records that id now lives at physOffset.
+func (lt *LookupTable) Set(id, physOffset uint64) error {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	rec := make([]byte, lookupRecordSize)
+	binary.BigEndian.PutUint64(rec[0:8], id)
+	binary.BigEndian.PutUint64(rec[8:16], physOffset)
+	if _, err := lt.file.Write(rec); err != nil {
+		return err
+	}
+	if err := lt.file.Sync(); err != nil {
+		return err
+	}
+	lt.offsets[id] = physOffset
+	return nil
+}
+
+// AllocateID mints a fresh logical id for physOffset and durably records
+// the mapping, returning the new id. Unlike Set, the caller doesn't choose
+// the id: it comes from a counter starting at logicalIDBase, so it can
+// never collide with a physical-offset-keyed id that Compact or Update
+// already put in this same table - see ColFile.insertLocked.
+func (lt *LookupTable) AllocateID(physOffset uint64) (uint64, error) {
+	lt.mu.Lock()
+	id := lt.nextID
+	lt.nextID++
+	lt.mu.Unlock()
+	if err := lt.Set(id, physOffset); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// CollapseValue rewrites every entry currently pointing at oldOffset so it
+// points at newOffset instead. Compact and Update's realloc fallback call
+// this for the offset they're about to vacate, before recording any new
+// mapping onto it - that keeps every entry a single hop from its
+// document's true physical offset, which is what lets resolveID do a
+// plain lookup instead of chasing a chain (see resolveID).
+func (lt *LookupTable) CollapseValue(oldOffset, newOffset uint64) error {
+	lt.mu.Lock()
+	var affected []uint64
+	for id, val := range lt.offsets {
+		if val == oldOffset {
+			affected = append(affected, id)
+		}
+	}
+	lt.mu.Unlock()
+	for _, id := range affected {
+		if err := lt.Set(id, newOffset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lookup returns the physical offset id currently maps to, and whether id
+// has ever been recorded at all.
+func (lt *LookupTable) Lookup(id uint64) (physOffset uint64, ok bool) {
+	lt.mu.RLock()
+	defer lt.mu.RUnlock()
+	physOffset, ok = lt.offsets[id]
+	return
+}
+
+// Len returns the number of ids currently recorded in the table.
+func (lt *LookupTable) Len() int {
+	lt.mu.RLock()
+	defer lt.mu.RUnlock()
+	return len(lt.offsets)
+}
+
+// Rewrite atomically replaces the on-disk log with exactly one record per
+// id currently in memory, collapsing however many Set calls accumulated
+// for it. Compact calls this once it has finished moving documents, so the
+// log doesn't grow without bound over the life of a collection.
+func (lt *LookupTable) Rewrite() error {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	path := lt.file.Name()
+	tmpPath := path + ".rewrite.tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	for id, physOffset := range lt.offsets {
+		rec := make([]byte, lookupRecordSize)
+		binary.BigEndian.PutUint64(rec[0:8], id)
+		binary.BigEndian.PutUint64(rec[8:16], physOffset)
+		if _, err := tmp.Write(rec); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := lt.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	lt.file = f
+	return nil
+}
+
+// Close releases the lookup table's file handle.
+func (lt *LookupTable) Close() error {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	return lt.file.Close()
+}
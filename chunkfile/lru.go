@@ -0,0 +1,48 @@
+package chunkfile
+
+import "container/list"
+
+// chunkLRU caches decompressed chunk payloads by file offset, bounded to a
+// fixed number of entries, so repeated random reads against the same chunk
+// don't re-inflate it every time. It is not safe for concurrent use on its
+// own; SeekableColFile guards it with its own mutex.
+type chunkLRU struct {
+	cap   int
+	ll    *list.List
+	items map[uint64]*list.Element
+}
+
+type chunkLRUEntry struct {
+	offset  uint64
+	payload []byte
+}
+
+func newChunkLRU(capacity int) *chunkLRU {
+	return &chunkLRU{cap: capacity, ll: list.New(), items: make(map[uint64]*list.Element)}
+}
+
+func (c *chunkLRU) get(offset uint64) ([]byte, bool) {
+	el, ok := c.items[offset]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*chunkLRUEntry).payload, true
+}
+
+func (c *chunkLRU) put(offset uint64, payload []byte) {
+	if el, ok := c.items[offset]; ok {
+		el.Value.(*chunkLRUEntry).payload = payload
+		c.ll.MoveToFront(el)
+		return
+	}
+	el := c.ll.PushFront(&chunkLRUEntry{offset: offset, payload: payload})
+	c.items[offset] = el
+	if c.ll.Len() > c.cap {
+		oldest := c.ll.Back()
+		if oldest != nil {
+			c.ll.Remove(oldest)
+			delete(c.items, oldest.Value.(*chunkLRUEntry).offset)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package chunkfile
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// memDoc is a single document stored by the in-memory driver.
+type memDoc struct {
+	valid bool
+	data  []byte
+}
+
+// MemColFile is a Driver that keeps every document in memory and never
+// touches disk. It's useful for tests and for ephemeral caches where mmap's
+// durability guarantees aren't wanted.
+type MemColFile struct {
+	mu   sync.RWMutex
+	docs map[uint64]*memDoc
+	next uint64
+}
+
+// OpenMemCol creates a new, empty in-memory collection. name is accepted
+// only so MemColFile's opener matches the Opener signature used by every
+// other driver; it has no other effect.
+func OpenMemCol(name string) (*MemColFile, error) {
+	return &MemColFile{docs: make(map[uint64]*memDoc)}, nil
+}
+
+// Read retrieves document data given its ID. Like the mmap driver, it
+// returns (nil, nil) for a tombstoned document; unlike the mmap driver,
+// which reports an error for an id it cannot place, it also returns
+// (nil, nil) for an id it has never issued.
+func (col *MemColFile) Read(id uint64) ([]byte, error) {
+	col.mu.RLock()
+	defer col.mu.RUnlock()
+	doc, ok := col.docs[id]
+	if !ok || !doc.valid {
+		return nil, nil
+	}
+	docCopy := make([]byte, len(doc.data))
+	copy(docCopy, doc.data)
+	return docCopy, nil
+}
+
+// Insert a document, return its ID.
+func (col *MemColFile) Insert(data []byte) (id uint64, err error) {
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	id = col.next
+	col.next++
+	docCopy := make([]byte, len(data))
+	copy(docCopy, data)
+	col.docs[id] = &memDoc{valid: true, data: docCopy}
+	return id, nil
+}
+
+// Update a document, return its new ID. Unlike the mmap driver, there is no
+// room to outgrow, so the ID never changes.
+func (col *MemColFile) Update(id uint64, data []byte) (newID uint64, err error) {
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	doc, ok := col.docs[id]
+	if !ok || !doc.valid {
+		return 0, fmt.Errorf("chunkfile: document %d does not exist", id)
+	}
+	docCopy := make([]byte, len(data))
+	copy(docCopy, data)
+	doc.data = docCopy
+	return id, nil
+}
+
+// Delete a document.
+func (col *MemColFile) Delete(id uint64) {
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	if doc, ok := col.docs[id]; ok {
+		doc.valid = false
+	}
+}
+
+// ForAll scans every live document in ID order and invokes fun on each,
+// stopping early if fun returns false.
+func (col *MemColFile) ForAll(fun func(id uint64, doc []byte) bool) error {
+	col.mu.RLock()
+	ids := make([]uint64, 0, len(col.docs))
+	for id, doc := range col.docs {
+		if doc.valid {
+			ids = append(ids, id)
+		}
+	}
+	col.mu.RUnlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		col.mu.RLock()
+		doc, ok := col.docs[id]
+		var data []byte
+		if ok && doc.valid {
+			data = doc.data
+		}
+		col.mu.RUnlock()
+		if !ok || !doc.valid {
+			continue
+		}
+		if !fun(id, data) {
+			break
+		}
+	}
+	return nil
+}
+
+// Sync is a no-op: there is no disk to flush to.
+func (col *MemColFile) Sync() error { return nil }
+
+// Close is a no-op: there are no resources to release.
+func (col *MemColFile) Close() error { return nil }
+
+func init() {
+	Register("mem", func(name string) (Driver, error) {
+		return OpenMemCol(name)
+	})
+}
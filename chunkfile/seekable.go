@@ -0,0 +1,784 @@
+package chunkfile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"github.com/steveoc64/tiedot/tdlog"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	seekableChunkDocs  = 256                // seal the hot chunk after this many documents...
+	seekableChunkBytes = uint64(256 * 1024) // ...or this many raw bytes, whichever comes first
+	seekableCacheSize  = 16                 // decompressed chunks kept in memory at once
+
+	// seekableTrailerSize is the fixed size of the trailer written after
+	// the Table of Contents: magic(4) + tocOffset(8) + tocLen(8) + tocCRC(4).
+	seekableTrailerSize = 4 + 8 + 8 + 4
+)
+
+// seekableMagic identifies a SeekableColFile trailer, so Open can tell a
+// well-formed trailer from a missing or truncated one.
+var seekableMagic = [4]byte{'T', 'D', 'S', 'K'}
+
+// tocEntry locates one document inside the chunk stream.
+type tocEntry struct {
+	chunkOffset uint64 // file offset of the chunk's 12-byte header
+	chunkLen    uint64 // length of the chunk's compressed bytes (excludes the 12-byte header)
+	inChunkOff  uint64 // offset of the document within the decompressed chunk
+	docLen      uint64 // length of the document
+	valid       bool
+}
+
+// tocRecord is tocEntry's gob-friendly, exported-field twin.
+type tocRecord struct {
+	ID                                       uint64
+	ChunkOffset, ChunkLen, InChunkOff, DocLen uint64
+}
+
+type hotDoc struct {
+	id   uint64
+	data []byte
+}
+
+// SeekableColFile is a Driver, inspired by eStargz's seekable-tar format,
+// that appends documents into gzip-compressed chunks of up to
+// seekableChunkDocs documents each, and keeps a Table of Contents at the
+// end of the file mapping every document id to its chunk and offset within
+// it. Read(id) only has to decompress the one chunk holding id, so random
+// reads stay O(1) chunks rather than O(file size).
+type SeekableColFile struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+
+	toc    map[uint64]*tocEntry
+	nextID uint64
+
+	hot      []hotDoc // documents buffered for the chunk currently being filled
+	hotBytes uint64
+
+	chunkCache *chunkLRU
+
+	// tombstones durably records deletes between one Sync and the next, so
+	// rebuildTOC can still honor them if it has to reconstruct the Table
+	// of Contents from the raw chunk stream - see its doc comment.
+	tombstones *seekableTombstoneLog
+}
+
+// seekableTombstoneLog durably records ids that Delete (or Update's
+// tombstone-and-reinsert) have invalidated, as a flat append-only log of
+// 8-byte ids - the same write-ahead-log recipe LookupTable uses. The chunk
+// stream itself carries no delete marker: a sealed chunk's bytes are
+// immutable, so a tombstone only ever exists as the in-memory toc entry's
+// valid flag and, once Sync runs, its absence from the persisted trailer.
+// rebuildTOC has no trailer to consult, so without this log it would mark
+// every document it finds in the chunk bytes as valid again, tombstoned or
+// not.
+type seekableTombstoneLog struct {
+	f *os.File
+}
+
+// openSeekableTombstoneLog opens (or creates) the tombstone log at path and
+// returns every id recorded in it alongside the handle.
+func openSeekableTombstoneLog(path string) (*seekableTombstoneLog, []uint64, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	size := info.Size()
+	size -= size % 8
+	buf := make([]byte, size)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	ids := make([]uint64, 0, len(buf)/8)
+	for off := 0; off+8 <= len(buf); off += 8 {
+		ids = append(ids, binary.BigEndian.Uint64(buf[off:off+8]))
+	}
+	return &seekableTombstoneLog{f: f}, ids, nil
+}
+
+// append durably records id as tombstoned.
+func (l *seekableTombstoneLog) append(id uint64) error {
+	rec := make([]byte, 8)
+	binary.BigEndian.PutUint64(rec, id)
+	if _, err := l.f.Write(rec); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// reset clears the log once its tombstones are reflected in a freshly
+// written trailer (which omits them outright), so the log doesn't grow
+// without bound over the life of a collection.
+func (l *seekableTombstoneLog) reset() error {
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := l.f.Seek(0, 0)
+	return err
+}
+
+func (l *seekableTombstoneLog) Close() error {
+	return l.f.Close()
+}
+
+// OpenSeekableCol opens (or creates) a seekable collection file. If the
+// file already has a well-formed trailer, its Table of Contents is loaded
+// directly; otherwise (the trailer is missing or was truncated, e.g. by a
+// crash mid-write) the TOC is rebuilt by scanning chunks from the start of
+// the file. Either way, the durable tombstone log is then replayed over
+// the result, so a delete recorded since the last Sync - or a trailer that
+// itself went missing - can't resurrect a deleted document.
+func OpenSeekableCol(name string) (*SeekableColFile, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	tombstones, deletedIDs, err := openSeekableTombstoneLog(name + ".tombstones")
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	col := &SeekableColFile{
+		path:       name,
+		f:          f,
+		toc:        make(map[uint64]*tocEntry),
+		chunkCache: newChunkLRU(seekableCacheSize),
+		tombstones: tombstones,
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		tombstones.Close()
+		return nil, err
+	}
+	if info.Size() > 0 {
+		if err := col.loadTrailer(info.Size()); err != nil {
+			tdlog.Errorf("ERROR: %s has no usable trailer (%v), rebuilding Table of Contents by scanning chunks", name, err)
+			if err := col.rebuildTOC(info.Size()); err != nil {
+				f.Close()
+				tombstones.Close()
+				return nil, err
+			}
+		}
+	}
+	for _, id := range deletedIDs {
+		if entry, ok := col.toc[id]; ok {
+			entry.valid = false
+		}
+	}
+	for id := range col.toc {
+		if id >= col.nextID {
+			col.nextID = id + 1
+		}
+	}
+	return col, nil
+}
+
+// loadTrailer reads the trailer at the end of the file, verifies the Table
+// of Contents it points to, and loads it. On success it truncates the file
+// back to the start of the TOC, so the next Sync call appends a fresh
+// trailer in the same place rather than growing the file forever.
+func (col *SeekableColFile) loadTrailer(fileSize int64) error {
+	if fileSize < seekableTrailerSize {
+		return fmt.Errorf("file too small for a trailer")
+	}
+	trailer := make([]byte, seekableTrailerSize)
+	if _, err := col.f.ReadAt(trailer, fileSize-seekableTrailerSize); err != nil {
+		return err
+	}
+	if !bytes.Equal(trailer[0:4], seekableMagic[:]) {
+		return fmt.Errorf("missing trailer magic")
+	}
+	tocOffset := binary.BigEndian.Uint64(trailer[4:12])
+	tocLen := binary.BigEndian.Uint64(trailer[12:20])
+	tocCRC := binary.BigEndian.Uint32(trailer[20:24])
+	if int64(tocOffset+tocLen)+seekableTrailerSize != fileSize {
+		return fmt.Errorf("trailer offsets do not match file size")
+	}
+	tocBytes := make([]byte, tocLen)
+	if _, err := col.f.ReadAt(tocBytes, int64(tocOffset)); err != nil {
+		return err
+	}
+	if crc32.ChecksumIEEE(tocBytes) != tocCRC {
+		return fmt.Errorf("Table of Contents checksum mismatch")
+	}
+	var entries []tocRecord
+	if err := gob.NewDecoder(bytes.NewReader(tocBytes)).Decode(&entries); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		col.toc[e.ID] = &tocEntry{chunkOffset: e.ChunkOffset, chunkLen: e.ChunkLen, inChunkOff: e.InChunkOff, docLen: e.DocLen, valid: true}
+	}
+	return col.f.Truncate(int64(tocOffset))
+}
+
+// rebuildTOC reconstructs the Table of Contents by walking the chunk
+// stream from the beginning of the file. It stops at the first chunk
+// header or compressed payload that does not check out, on the theory that
+// a half-written chunk can only be at the very end of the file, and
+// truncates away anything from that point on.
+func (col *SeekableColFile) rebuildTOC(fileSize int64) error {
+	offset := int64(0)
+	for offset+12 <= fileSize {
+		hdr := make([]byte, 12)
+		if _, err := col.f.ReadAt(hdr, offset); err != nil {
+			return err
+		}
+		compLen := binary.BigEndian.Uint64(hdr[0:8])
+		storedCRC := binary.BigEndian.Uint32(hdr[8:12])
+		if compLen == 0 || offset+12+int64(compLen) > fileSize {
+			break
+		}
+		compressed := make([]byte, compLen)
+		if _, err := col.f.ReadAt(compressed, offset+12); err != nil {
+			return err
+		}
+		if crc32.ChecksumIEEE(compressed) != storedCRC {
+			break
+		}
+		payload, err := gunzip(compressed)
+		if err != nil {
+			break
+		}
+		chunkOffset := uint64(offset)
+		pos := uint64(0)
+		for pos+12 <= uint64(len(payload)) {
+			id := binary.BigEndian.Uint64(payload[pos : pos+8])
+			docLen := uint64(binary.BigEndian.Uint32(payload[pos+8 : pos+12]))
+			inChunkOff := pos + 12
+			if inChunkOff+docLen > uint64(len(payload)) {
+				break
+			}
+			col.toc[id] = &tocEntry{chunkOffset: chunkOffset, chunkLen: compLen, inChunkOff: inChunkOff, docLen: docLen, valid: true}
+			pos = inChunkOff + docLen
+		}
+		offset += 12 + int64(compLen)
+	}
+	return col.f.Truncate(offset)
+}
+
+func gunzip(compressed []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return ioutil.ReadAll(gz)
+}
+
+// appendChunk compresses payload and appends it to the end of the file as
+// [compressed length (8)][CRC32 of compressed bytes (4)][compressed bytes].
+func (col *SeekableColFile) appendChunk(payload []byte) (offset, compLen uint64, err error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err = gz.Write(payload); err != nil {
+		return
+	}
+	if err = gz.Close(); err != nil {
+		return
+	}
+	compressed := buf.Bytes()
+
+	info, err := col.f.Stat()
+	if err != nil {
+		return
+	}
+	offset = uint64(info.Size())
+
+	hdr := make([]byte, 12)
+	binary.BigEndian.PutUint64(hdr[0:8], uint64(len(compressed)))
+	binary.BigEndian.PutUint32(hdr[8:12], crc32.ChecksumIEEE(compressed))
+	if _, err = col.f.WriteAt(hdr, int64(offset)); err != nil {
+		return
+	}
+	if _, err = col.f.WriteAt(compressed, int64(offset)+12); err != nil {
+		return
+	}
+	compLen = uint64(len(compressed))
+	return
+}
+
+// readChunk decompresses the chunk at offset, using the chunk cache to
+// avoid re-inflating the same chunk on every call.
+func (col *SeekableColFile) readChunk(offset, compLen uint64) ([]byte, error) {
+	if cached, ok := col.chunkCache.get(offset); ok {
+		return cached, nil
+	}
+	hdr := make([]byte, 12)
+	if _, err := col.f.ReadAt(hdr, int64(offset)); err != nil {
+		return nil, err
+	}
+	storedLen := binary.BigEndian.Uint64(hdr[0:8])
+	storedCRC := binary.BigEndian.Uint32(hdr[8:12])
+	if storedLen != compLen {
+		return nil, ErrCorruptHeader
+	}
+	compressed := make([]byte, compLen)
+	if _, err := col.f.ReadAt(compressed, int64(offset)+12); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(compressed) != storedCRC {
+		return nil, ErrChecksumMismatch
+	}
+	payload, err := gunzip(compressed)
+	if err != nil {
+		return nil, err
+	}
+	col.chunkCache.put(offset, payload)
+	return payload, nil
+}
+
+// sealHotChunk compresses and appends the buffered hot documents as a new
+// chunk, and records their location in the Table of Contents. It is a
+// no-op if there is nothing buffered.
+func (col *SeekableColFile) sealHotChunk() error {
+	if len(col.hot) == 0 {
+		return nil
+	}
+	var payload bytes.Buffer
+	inChunkOffsets := make([]uint64, len(col.hot))
+	for i, d := range col.hot {
+		inChunkOffsets[i] = uint64(payload.Len()) + 12
+		var rec [12]byte
+		binary.BigEndian.PutUint64(rec[0:8], d.id)
+		binary.BigEndian.PutUint32(rec[8:12], uint32(len(d.data)))
+		payload.Write(rec[:])
+		payload.Write(d.data)
+	}
+	chunkOffset, chunkLen, err := col.appendChunk(payload.Bytes())
+	if err != nil {
+		return err
+	}
+	for i, d := range col.hot {
+		col.toc[d.id] = &tocEntry{
+			chunkOffset: chunkOffset,
+			chunkLen:    chunkLen,
+			inChunkOff:  inChunkOffsets[i],
+			docLen:      uint64(len(d.data)),
+			valid:       true,
+		}
+	}
+	col.hot = col.hot[:0]
+	col.hotBytes = 0
+	return nil
+}
+
+// Read retrieves document data given its ID, decompressing the one chunk
+// that holds it.
+func (col *SeekableColFile) Read(id uint64) ([]byte, error) {
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	for _, d := range col.hot {
+		if d.id == id {
+			out := make([]byte, len(d.data))
+			copy(out, d.data)
+			return out, nil
+		}
+	}
+	entry, ok := col.toc[id]
+	if !ok || !entry.valid {
+		return nil, nil
+	}
+	payload, err := col.readChunk(entry.chunkOffset, entry.chunkLen)
+	if err != nil {
+		return nil, err
+	}
+	if entry.inChunkOff+entry.docLen > uint64(len(payload)) {
+		return nil, ErrOutOfBounds
+	}
+	docCopy := make([]byte, entry.docLen)
+	copy(docCopy, payload[entry.inChunkOff:entry.inChunkOff+entry.docLen])
+	return docCopy, nil
+}
+
+// Insert buffers data into the current hot chunk, sealing and compressing
+// it once it reaches seekableChunkDocs documents or seekableChunkBytes
+// bytes.
+func (col *SeekableColFile) Insert(data []byte) (id uint64, err error) {
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	id = col.nextID
+	col.nextID++
+	docCopy := make([]byte, len(data))
+	copy(docCopy, data)
+	col.hot = append(col.hot, hotDoc{id: id, data: docCopy})
+	col.hotBytes += uint64(len(docCopy))
+	if len(col.hot) >= seekableChunkDocs || col.hotBytes >= seekableChunkBytes {
+		err = col.sealHotChunk()
+	}
+	return id, err
+}
+
+// Update tombstones id and writes data as a new document, same as the
+// mmap driver falls back to when an update no longer fits in place -
+// chunks are immutable once sealed, so there is no in-place path here at
+// all.
+func (col *SeekableColFile) Update(id uint64, data []byte) (newID uint64, err error) {
+	col.mu.Lock()
+	if entry, ok := col.toc[id]; ok {
+		if entry.valid {
+			entry.valid = false
+			if logErr := col.tombstones.append(id); logErr != nil {
+				tdlog.Errorf("ERROR: %s: failed to durably record tombstone for document %d: %v", col.path, id, logErr)
+			}
+		}
+	} else {
+		for i, d := range col.hot {
+			if d.id == id {
+				col.hot = append(col.hot[:i], col.hot[i+1:]...)
+				break
+			}
+		}
+	}
+	col.mu.Unlock()
+	return col.Insert(data)
+}
+
+// Delete tombstones a document.
+func (col *SeekableColFile) Delete(id uint64) {
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	if entry, ok := col.toc[id]; ok {
+		if entry.valid {
+			entry.valid = false
+			if err := col.tombstones.append(id); err != nil {
+				tdlog.Errorf("ERROR: %s: failed to durably record tombstone for document %d: %v", col.path, id, err)
+			}
+		}
+		return
+	}
+	for i, d := range col.hot {
+		if d.id == id {
+			col.hot = append(col.hot[:i], col.hot[i+1:]...)
+			return
+		}
+	}
+}
+
+// ForAll scans every live document in ID order and invokes fun on each,
+// stopping early if fun returns false.
+func (col *SeekableColFile) ForAll(fun func(id uint64, doc []byte) bool) error {
+	col.mu.Lock()
+	ids := make([]uint64, 0, len(col.toc)+len(col.hot))
+	for id, e := range col.toc {
+		if e.valid {
+			ids = append(ids, id)
+		}
+	}
+	for _, d := range col.hot {
+		ids = append(ids, d.id)
+	}
+	col.mu.Unlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		doc, err := col.Read(id)
+		if err != nil {
+			return err
+		}
+		if doc == nil {
+			continue
+		}
+		if !fun(id, doc) {
+			break
+		}
+	}
+	return nil
+}
+
+// Sync seals the hot chunk (if any) and writes a fresh Table of Contents
+// trailer, so the file is fully recoverable without a rebuild scan.
+func (col *SeekableColFile) Sync() error {
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	return col.syncLocked()
+}
+
+func (col *SeekableColFile) syncLocked() error {
+	if err := col.sealHotChunk(); err != nil {
+		return err
+	}
+	if err := col.writeTrailer(); err != nil {
+		return err
+	}
+	// The trailer just written already omits every tombstoned id, so the
+	// log recording them since the last Sync has nothing left to add.
+	return col.tombstones.reset()
+}
+
+func (col *SeekableColFile) writeTrailer() error {
+	info, err := col.f.Stat()
+	if err != nil {
+		return err
+	}
+	tocOffset := uint64(info.Size())
+
+	entries := make([]tocRecord, 0, len(col.toc))
+	for id, e := range col.toc {
+		if !e.valid {
+			continue
+		}
+		entries = append(entries, tocRecord{ID: id, ChunkOffset: e.chunkOffset, ChunkLen: e.chunkLen, InChunkOff: e.inChunkOff, DocLen: e.docLen})
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entries); err != nil {
+		return err
+	}
+	tocBytes := buf.Bytes()
+	if _, err := col.f.WriteAt(tocBytes, int64(tocOffset)); err != nil {
+		return err
+	}
+
+	trailer := make([]byte, seekableTrailerSize)
+	copy(trailer[0:4], seekableMagic[:])
+	binary.BigEndian.PutUint64(trailer[4:12], tocOffset)
+	binary.BigEndian.PutUint64(trailer[12:20], uint64(len(tocBytes)))
+	binary.BigEndian.PutUint32(trailer[20:24], crc32.ChecksumIEEE(tocBytes))
+	if _, err := col.f.WriteAt(trailer, int64(tocOffset)+int64(len(tocBytes))); err != nil {
+		return err
+	}
+	return col.f.Sync()
+}
+
+// Close flushes the file (see Sync) and releases its file handle.
+func (col *SeekableColFile) Close() error {
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	syncErr := col.syncLocked()
+	tombErr := col.tombstones.Close()
+	closeErr := col.f.Close()
+	if syncErr != nil {
+		return syncErr
+	}
+	if tombErr != nil {
+		return tombErr
+	}
+	return closeErr
+}
+
+// SeekableCompactStats reports the outcome of a Compact pass.
+type SeekableCompactStats struct {
+	ChunksRewritten int   // chunks that were decompressed, stripped of tombstones and recompressed
+	ChunksDropped   int   // chunks with zero surviving documents, removed entirely
+	ChunksKept      int   // chunks copied verbatim because their live-ratio was already high enough
+	BytesReclaimed  int64 // shrinkage in on-disk size
+}
+
+// chunkGroup tallies, for one chunk, how many documents it originally held
+// versus how many are still valid.
+type chunkGroup struct {
+	offset, length uint64
+	ids            []uint64
+	live           int
+}
+
+// Compact rewrites every chunk whose live-ratio (valid documents / total
+// documents the chunk ever held) falls below ratio, dropping tombstoned
+// documents; chunks at or above ratio are copied across untouched. The
+// rewrite is assembled in a sibling file, and only swapped into place with
+// an atomic rename once it - and a fresh Table of Contents trailer - are
+// completely written, so a crash mid-compaction leaves the original file
+// exactly as it was.
+func (col *SeekableColFile) Compact(ratio float64) (SeekableCompactStats, error) {
+	col.mu.Lock()
+	defer col.mu.Unlock()
+
+	var stats SeekableCompactStats
+	if err := col.sealHotChunk(); err != nil {
+		return stats, err
+	}
+	beforeInfo, err := col.f.Stat()
+	if err != nil {
+		return stats, err
+	}
+
+	groups := make(map[uint64]*chunkGroup)
+	for id, e := range col.toc {
+		g, ok := groups[e.chunkOffset]
+		if !ok {
+			g = &chunkGroup{offset: e.chunkOffset, length: e.chunkLen}
+			groups[e.chunkOffset] = g
+		}
+		g.ids = append(g.ids, id)
+		if e.valid {
+			g.live++
+		}
+	}
+	offsets := make([]uint64, 0, len(groups))
+	for off := range groups {
+		offsets = append(offsets, off)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	tmpPath := col.path + ".compact.tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return stats, err
+	}
+	defer os.Remove(tmpPath) // no-op once the rename below succeeds
+
+	newTOC := make(map[uint64]*tocEntry)
+	var writeOffset int64
+	for _, off := range offsets {
+		g := groups[off]
+		if g.live == len(g.ids) || float64(g.live)/float64(len(g.ids)) >= ratio {
+			if err := col.copyChunkVerbatim(tmp, g, writeOffset, newTOC); err != nil {
+				tmp.Close()
+				return stats, err
+			}
+			writeOffset += int64(12 + g.length)
+			stats.ChunksKept++
+			continue
+		}
+		if g.live == 0 {
+			stats.ChunksDropped++
+			continue
+		}
+		written, err := col.rewriteChunk(tmp, g, writeOffset, newTOC)
+		if err != nil {
+			tmp.Close()
+			return stats, err
+		}
+		writeOffset += written
+		stats.ChunksRewritten++
+	}
+	// Write the new Table of Contents trailer into tmp before the rename, so
+	// the file that appears at col.path is complete from the start.
+	origF, origTOC := col.f, col.toc
+	col.f, col.toc = tmp, newTOC
+	err = col.writeTrailer()
+	col.f, col.toc = origF, origTOC
+	if err != nil {
+		tmp.Close()
+		return stats, err
+	}
+	if err := tmp.Close(); err != nil {
+		return stats, err
+	}
+
+	if err := os.Rename(tmpPath, col.path); err != nil {
+		return stats, err
+	}
+	if err := col.f.Close(); err != nil {
+		return stats, err
+	}
+	f, err := os.OpenFile(col.path, os.O_RDWR, 0644)
+	if err != nil {
+		return stats, err
+	}
+	col.f = f
+	col.toc = newTOC
+	col.chunkCache = newChunkLRU(seekableCacheSize)
+
+	// The trailer now in place already omits every tombstoned id.
+	if err := col.tombstones.reset(); err != nil {
+		return stats, err
+	}
+	afterInfo, err := f.Stat()
+	if err != nil {
+		return stats, err
+	}
+	stats.BytesReclaimed = beforeInfo.Size() - afterInfo.Size()
+	return stats, nil
+}
+
+// copyChunkVerbatim copies a chunk's on-disk bytes unchanged to tmp at
+// writeOffset, and carries over its still-valid Table of Contents entries
+// pointing at the new offset.
+func (col *SeekableColFile) copyChunkVerbatim(tmp *os.File, g *chunkGroup, writeOffset int64, newTOC map[uint64]*tocEntry) error {
+	raw := make([]byte, 12+g.length)
+	if _, err := col.f.ReadAt(raw, int64(g.offset)); err != nil {
+		return err
+	}
+	if _, err := tmp.WriteAt(raw, writeOffset); err != nil {
+		return err
+	}
+	for _, id := range g.ids {
+		e := col.toc[id]
+		if !e.valid {
+			continue
+		}
+		newTOC[id] = &tocEntry{chunkOffset: uint64(writeOffset), chunkLen: e.chunkLen, inChunkOff: e.inChunkOff, docLen: e.docLen, valid: true}
+	}
+	return nil
+}
+
+// rewriteChunk decompresses a chunk, keeps only its still-valid documents,
+// recompresses them as a new chunk at writeOffset in tmp, and returns the
+// number of bytes written (12-byte header included).
+func (col *SeekableColFile) rewriteChunk(tmp *os.File, g *chunkGroup, writeOffset int64, newTOC map[uint64]*tocEntry) (int64, error) {
+	payload, err := col.readChunk(g.offset, g.length)
+	if err != nil {
+		return 0, err
+	}
+	var rebuilt bytes.Buffer
+	type survivor struct {
+		id, inChunkOff, docLen uint64
+	}
+	var survivors []survivor
+	for _, id := range g.ids {
+		e := col.toc[id]
+		if !e.valid {
+			continue
+		}
+		var rec [12]byte
+		binary.BigEndian.PutUint64(rec[0:8], id)
+		binary.BigEndian.PutUint32(rec[8:12], uint32(e.docLen))
+		survivors = append(survivors, survivor{id: id, inChunkOff: uint64(rebuilt.Len()) + 12, docLen: e.docLen})
+		rebuilt.Write(rec[:])
+		rebuilt.Write(payload[e.inChunkOff : e.inChunkOff+e.docLen])
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(rebuilt.Bytes()); err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+
+	hdr := make([]byte, 12)
+	binary.BigEndian.PutUint64(hdr[0:8], uint64(compressed.Len()))
+	binary.BigEndian.PutUint32(hdr[8:12], crc32.ChecksumIEEE(compressed.Bytes()))
+	if _, err := tmp.WriteAt(hdr, writeOffset); err != nil {
+		return 0, err
+	}
+	if _, err := tmp.WriteAt(compressed.Bytes(), writeOffset+12); err != nil {
+		return 0, err
+	}
+
+	for _, s := range survivors {
+		newTOC[s.id] = &tocEntry{
+			chunkOffset: uint64(writeOffset),
+			chunkLen:    uint64(compressed.Len()),
+			inChunkOff:  s.inChunkOff,
+			docLen:      s.docLen,
+			valid:       true,
+		}
+	}
+	return int64(12 + compressed.Len()), nil
+}
+
+func init() {
+	Register("seekable", func(name string) (Driver, error) {
+		return OpenSeekableCol(name)
+	})
+}
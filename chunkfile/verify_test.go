@@ -0,0 +1,124 @@
+package chunkfile
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func newTestCol(t *testing.T) (*ColFile, string) {
+	dir, err := ioutil.TempDir("", "chunkfile_verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := OpenCol(path.Join(dir, "col"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return col, dir
+}
+
+func TestOpenColDetectsVersion(t *testing.T) {
+	col, dir := newTestCol(t)
+	defer os.RemoveAll(dir)
+
+	if col.version != FileVersionCRC {
+		t.Fatalf("expected a new collection file to be FileVersionCRC, got %d", col.version)
+	}
+}
+
+func TestReadDetectsChecksumMismatch(t *testing.T) {
+	col, dir := newTestCol(t)
+	defer os.RemoveAll(dir)
+
+	id, err := col.Insert([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip a byte inside the document body without touching its header.
+	col.File.Buf[id+col.docHeaderSize()] ^= 0xFF
+
+	if _, err := col.Read(id); err != ErrChecksumMismatch {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+func TestVerifyFindsBadChecksum(t *testing.T) {
+	col, dir := newTestCol(t)
+	defer os.RemoveAll(dir)
+
+	id, err := col.Insert([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := col.Insert([]byte("still good")); err != nil {
+		t.Fatal(err)
+	}
+	col.File.Buf[id+col.docHeaderSize()] ^= 0xFF
+
+	report, err := col.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.BadChecksums) != 1 || report.BadChecksums[0] != id {
+		t.Fatalf("expected exactly document %d flagged, got %v", id, report.BadChecksums)
+	}
+}
+
+func TestRepairTombstoneDeletesBadChecksums(t *testing.T) {
+	col, dir := newTestCol(t)
+	defer os.RemoveAll(dir)
+
+	id, err := col.Insert([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	col.File.Buf[id+col.docHeaderSize()] ^= 0xFF
+
+	if err := col.Repair(RepairTombstone); err != nil {
+		t.Fatal(err)
+	}
+	if col.File.Buf[id] != DOC_INVALID {
+		t.Fatalf("expected document %d to be tombstoned after repair", id)
+	}
+}
+
+func TestVerifyJSONReportsBadChecksums(t *testing.T) {
+	col, dir := newTestCol(t)
+	defer os.RemoveAll(dir)
+
+	id, err := col.Insert([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	col.File.Buf[id+col.docHeaderSize()] ^= 0xFF
+
+	out, err := col.VerifyJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report VerifyReport
+	if err := json.Unmarshal(out, &report); err != nil {
+		t.Fatalf("VerifyJSON produced invalid JSON: %v", err)
+	}
+	if len(report.BadChecksums) != 1 || report.BadChecksums[0] != id {
+		t.Fatalf("expected exactly document %d flagged, got %v", id, report.BadChecksums)
+	}
+}
+
+func TestLegacyFileHasNoChecksums(t *testing.T) {
+	col, dir := newTestCol(t)
+	defer os.RemoveAll(dir)
+	col.version = FileVersionLegacy
+
+	report, err := col.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.BadChecksums) != 0 || report.DocsScanned != 0 {
+		t.Fatalf("expected Verify to be a no-op on a legacy file, got %+v", report)
+	}
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"flag"
+	"github.com/steveoc64/tiedot/chunkfile"
 	"github.com/steveoc64/tiedot/db"
 	"github.com/steveoc64/tiedot/srv/v3"
 	"github.com/steveoc64/tiedot/tdlog"
@@ -25,11 +26,12 @@ func main() {
 	}
 
 	// Parse CLI parameters
-	var mode, dir string
+	var mode, dir, driver string
 	var port, maxprocs, benchSize int
 	var profile bool
 	flag.StringVar(&mode, "mode", "", "[httpd|bench|bench2|example]")
 	flag.StringVar(&dir, "dir", "", "database directory")
+	flag.StringVar(&driver, "driver", "mmap", "default collection storage driver [mmap|mem|aes-gcm|seekable]")
 	flag.IntVar(&port, "port", 8080, "listening port number")
 	flag.IntVar(&maxprocs, "gomaxprocs", defaultMaxprocs, "GOMAXPROCS")
 	flag.IntVar(&benchSize, "benchsize", 400000, "Benchmark sample size")
@@ -42,6 +44,13 @@ func main() {
 		return
 	}
 
+	// -driver only sets the process-wide default: a collection can still
+	// be opened under a different driver if db's own metadata says so, via
+	// chunkfile.Open's driverName parameter.
+	if err := chunkfile.SetDefaultDriver(driver); err != nil {
+		tdlog.Fatal(err)
+	}
+
 	// Setup appropriate GOMAXPROCS parameter
 	runtime.GOMAXPROCS(maxprocs)
 	log.Printf("GOMAXPROCS is set to %d", maxprocs)